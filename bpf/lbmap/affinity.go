@@ -0,0 +1,116 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package lbmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"github.com/cilium/cilium/common"
+	"github.com/cilium/cilium/common/bpf"
+	"github.com/cilium/cilium/common/types"
+)
+
+// ServiceFlags carries the Flags bits of a Service4Value/Service6Value.
+type ServiceFlags uint16
+
+const (
+	// ServiceFlagSessionAffinity sticks a client to the backend it was
+	// last sent to. AffinityMatch4Map records that backend and when it was
+	// last used; no affinity timeout is stored in it.
+	ServiceFlagSessionAffinity ServiceFlags = 1 << 0
+)
+
+// AffinityMatch4Map tracks which backend a client with session affinity
+// enabled was last sent to, keyed by the client's address and the
+// service's RevNAT ID.
+var AffinityMatch4Map = bpf.NewMap(common.BPFCiliumMaps+"/cilium_lb4_affinity",
+	bpf.MapTypeHash,
+	int(unsafe.Sizeof(AffinityMatch4Key{})),
+	int(unsafe.Sizeof(AffinityMatch4Value{})),
+	lbMapMaxEntries(affinityMapWeight, int(unsafe.Sizeof(AffinityMatch4Key{})), int(unsafe.Sizeof(AffinityMatch4Value{}))))
+
+// Must match 'struct lb4_affinity_key' in "bpf/lib/common.h"
+type AffinityMatch4Key struct {
+	ClientIP types.IPv4
+	RevNAT   uint16
+	Pad      uint16
+}
+
+func (k AffinityMatch4Key) IsIPv6() bool           { return false }
+func (k AffinityMatch4Key) Map() *bpf.Map          { return AffinityMatch4Map }
+func (k AffinityMatch4Key) NewValue() bpf.MapValue { return &AffinityMatch4Value{} }
+
+func (k AffinityMatch4Key) GetKeyPtr() unsafe.Pointer {
+	return unsafe.Pointer(&k)
+}
+
+func (k AffinityMatch4Key) MapDelete() error {
+	return k.Map().Delete(k)
+}
+
+func NewAffinityMatch4Key(clientIP net.IP, revNat uint16) *AffinityMatch4Key {
+	key := AffinityMatch4Key{
+		RevNAT: common.Swab16(revNat),
+	}
+
+	copy(key.ClientIP[:], clientIP.To4())
+
+	return &key
+}
+
+// Must match 'struct lb4_affinity_val' in "bpf/lib/common.h". LastUsed
+// comes first: the compiler 8-byte aligns it after a leading uint16/uint16
+// pair, but binary.Read packs fields with no padding, so the two views of
+// this struct would otherwise disagree on where LastUsed's bytes live.
+type AffinityMatch4Value struct {
+	LastUsed uint64
+	Slave    uint16
+	Pad      uint16
+}
+
+func (v AffinityMatch4Value) GetValuePtr() unsafe.Pointer {
+	return unsafe.Pointer(&v)
+}
+
+func NewAffinityMatch4Value(slave uint16, lastUsedNs uint64) *AffinityMatch4Value {
+	return &AffinityMatch4Value{
+		Slave:    slave,
+		LastUsed: lastUsedNs,
+	}
+}
+
+func AffinityMatch4DumpParser(key []byte, value []byte) (bpf.MapKey, bpf.MapValue, error) {
+	keyBuf := bytes.NewBuffer(key)
+	valueBuf := bytes.NewBuffer(value)
+	affKey := AffinityMatch4Key{}
+	affVal := AffinityMatch4Value{}
+
+	if err := binary.Read(keyBuf, binary.LittleEndian, &affKey); err != nil {
+		return nil, nil, fmt.Errorf("Unable to convert key: %s\n", err)
+	}
+
+	affKey.RevNAT = common.Swab16(affKey.RevNAT)
+
+	if err := binary.Read(valueBuf, binary.LittleEndian, &affVal); err != nil {
+		return nil, nil, fmt.Errorf("Unable to convert value: %s\n", err)
+	}
+
+	return &affKey, &affVal, nil
+}
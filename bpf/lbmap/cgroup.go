@@ -0,0 +1,74 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package lbmap
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupV2MemoryMaxPath  = "/sys/fs/cgroup/memory.max"
+	cgroupV1MemoryMaxPath  = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+	cgroupUnlimitedKeyword = "max"
+)
+
+// cgroupUnlimitedCeiling is a sanity ceiling for limits read from either
+// hierarchy. Cgroup v2 reports "max" for no limit, but an unconfined
+// cgroup v1 host instead reports a huge sentinel close to math.MaxInt64
+// (commonly 9223372036854771712, i.e. MaxInt64 rounded down to a page
+// boundary) rather than a literal string. No real host has anywhere near
+// this much memory, so treat anything at or above it as unlimited too.
+const cgroupUnlimitedCeiling = int64(1) << 62
+
+// cgroupMemoryLimit returns the memory limit of the cgroup the agent is
+// running in, preferring the unified cgroup v2 hierarchy and falling back
+// to cgroup v1. It returns ok == false when no limit could be determined,
+// e.g. the agent isn't confined by a cgroup, or the limit is unset.
+func cgroupMemoryLimit() (limit int64, ok bool) {
+	return cgroupMemoryLimitAt(cgroupV2MemoryMaxPath, cgroupV1MemoryMaxPath)
+}
+
+// cgroupMemoryLimitAt is cgroupMemoryLimit with the two candidate paths
+// taken as arguments so the v2-preferred-over-v1 dispatch can be unit
+// tested against temp files instead of the real /sys/fs/cgroup.
+func cgroupMemoryLimitAt(v2Path, v1Path string) (limit int64, ok bool) {
+	if n, ok := readCgroupMemoryLimit(v2Path); ok {
+		return n, true
+	}
+
+	return readCgroupMemoryLimit(v1Path)
+}
+
+func readCgroupMemoryLimit(path string) (int64, bool) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	value := strings.TrimSpace(string(raw))
+	if value == "" || value == cgroupUnlimitedKeyword {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 || n >= cgroupUnlimitedCeiling {
+		return 0, false
+	}
+
+	return n, true
+}
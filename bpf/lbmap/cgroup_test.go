@@ -0,0 +1,105 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package lbmap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFile(t *testing.T, content string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "lbmap-cgroup-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "memory.max")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write %s: %s", path, err)
+	}
+
+	return path
+}
+
+func TestReadCgroupMemoryLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		missing   bool
+		wantLimit int64
+		wantOK    bool
+	}{
+		{name: "normal limit", content: "536870912\n", wantLimit: 536870912, wantOK: true},
+		{name: "cgroup v2 unlimited", content: "max\n", wantOK: false},
+		{name: "cgroup v1 unconfined sentinel", content: "9223372036854771712\n", wantOK: false},
+		{name: "empty file", content: "", wantOK: false},
+		{name: "garbage", content: "not-a-number\n", wantOK: false},
+		{name: "zero", content: "0\n", wantOK: false},
+		{name: "negative", content: "-1\n", wantOK: false},
+		{name: "missing file", missing: true, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/does/not/exist/memory.max"
+			if !tt.missing {
+				path = writeCgroupFile(t, tt.content)
+			}
+
+			limit, ok := readCgroupMemoryLimit(path)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && limit != tt.wantLimit {
+				t.Fatalf("limit = %d, want %d", limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestCgroupMemoryLimitAtPrefersV2(t *testing.T) {
+	v2 := writeCgroupFile(t, "1048576\n")
+	v1 := writeCgroupFile(t, "2097152\n")
+
+	limit, ok := cgroupMemoryLimitAt(v2, v1)
+	if !ok || limit != 1048576 {
+		t.Fatalf("got (%d, %v), want (1048576, true)", limit, ok)
+	}
+}
+
+func TestCgroupMemoryLimitAtFallsBackToV1(t *testing.T) {
+	v1 := writeCgroupFile(t, "2097152\n")
+
+	limit, ok := cgroupMemoryLimitAt("/does/not/exist/memory.max", v1)
+	if !ok || limit != 2097152 {
+		t.Fatalf("got (%d, %v), want (2097152, true)", limit, ok)
+	}
+}
+
+func TestCgroupMemoryLimitAtUnlimitedFallsThrough(t *testing.T) {
+	v2 := writeCgroupFile(t, "max\n")
+	v1 := writeCgroupFile(t, "9223372036854771712\n")
+
+	if _, ok := cgroupMemoryLimitAt(v2, v1); ok {
+		t.Fatalf("expected no limit when both hierarchies report unlimited")
+	}
+}
@@ -32,12 +32,12 @@ var (
 		bpf.MapTypeHash,
 		int(unsafe.Sizeof(Service4Key{})),
 		int(unsafe.Sizeof(Service4Value{})),
-		maxEntries)
+		lbMapMaxEntries(serviceMapWeight, int(unsafe.Sizeof(Service4Key{})), int(unsafe.Sizeof(Service4Value{}))))
 	RevNat4Map = bpf.NewMap(common.BPFCiliumMaps+"/cilium_lb4_reverse_nat",
 		bpf.MapTypeHash,
 		int(unsafe.Sizeof(RevNat4Key(0))),
 		int(unsafe.Sizeof(RevNat4Value{})),
-		maxEntries)
+		lbMapMaxEntries(revNatMapWeight, int(unsafe.Sizeof(RevNat4Key(0))), int(unsafe.Sizeof(RevNat4Value{}))))
 )
 
 // Must match 'struct lb4_key' in "bpf/lib/common.h"
@@ -76,13 +76,29 @@ type Service4Value struct {
 	Port    uint16
 	Count   uint16
 	RevNAT  uint16
+	Weight  uint16
+	Flags   uint16
 }
 
-func NewService4Value(count uint16, target net.IP, port uint16, revNat uint16) *Service4Value {
+// legacyService4Value is the on-disk layout of Service4Value before Weight
+// and Flags were added. It lets Service4DumpParser keep reading maps
+// pinned by older agent versions until MigrateService4Map has run.
+type legacyService4Value struct {
+	Address types.IPv4
+	Port    uint16
+	Count   uint16
+	RevNAT  uint16
+}
+
+var legacyService4ValueSize = int(unsafe.Sizeof(legacyService4Value{}))
+
+func NewService4Value(count uint16, target net.IP, port uint16, revNat uint16, weight uint16, flags ServiceFlags) *Service4Value {
 	svc := Service4Value{
 		Count:  count,
 		RevNAT: common.Swab16(revNat),
 		Port:   common.Swab16(port),
+		Weight: weight,
+		Flags:  uint16(flags),
 	}
 
 	copy(svc.Address[:], target.To4())
@@ -96,9 +112,7 @@ func (s Service4Value) GetValuePtr() unsafe.Pointer {
 
 func Service4DumpParser(key []byte, value []byte) (bpf.MapKey, bpf.MapValue, error) {
 	keyBuf := bytes.NewBuffer(key)
-	valueBuf := bytes.NewBuffer(value)
 	svcKey := Service4Key{}
-	svcVal := Service4Value{}
 
 	if err := binary.Read(keyBuf, binary.LittleEndian, &svcKey); err != nil {
 		return nil, nil, fmt.Errorf("Unable to convert key: %s\n", err)
@@ -106,14 +120,73 @@ func Service4DumpParser(key []byte, value []byte) (bpf.MapKey, bpf.MapValue, err
 
 	svcKey.Port = common.Swab16(svcKey.Port)
 
-	if err := binary.Read(valueBuf, binary.LittleEndian, &svcVal); err != nil {
-		return nil, nil, fmt.Errorf("Unable to convert key: %s\n", err)
+	svcVal, err := parseService4Value(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &svcKey, svcVal, nil
+}
+
+// parseService4Value decodes a Service4Map value, transparently upgrading
+// entries still written in the pre-Weight/Flags layout so that a mixed
+// map left behind by an older agent can be read without a prior migration.
+func parseService4Value(value []byte) (*Service4Value, error) {
+	valueBuf := bytes.NewBuffer(value)
+	svcVal := Service4Value{}
+
+	if len(value) == legacyService4ValueSize {
+		legacy := legacyService4Value{}
+		if err := binary.Read(valueBuf, binary.LittleEndian, &legacy); err != nil {
+			return nil, fmt.Errorf("Unable to convert legacy value: %s\n", err)
+		}
+
+		svcVal.Address = legacy.Address
+		svcVal.Port = legacy.Port
+		svcVal.Count = legacy.Count
+		svcVal.RevNAT = legacy.RevNAT
+		svcVal.Weight = 1 // legacy entries were selected with equal probability
+	} else if err := binary.Read(valueBuf, binary.LittleEndian, &svcVal); err != nil {
+		return nil, fmt.Errorf("Unable to convert value: %s\n", err)
 	}
 
 	svcVal.Port = common.Swab16(svcVal.Port)
 	svcVal.RevNAT = common.Swab16(svcVal.RevNAT)
 
-	return &svcKey, &svcVal, nil
+	return &svcVal, nil
+}
+
+// MigrateService4Map rewrites every entry of Service4Map that is still
+// using the pre-Weight/Flags layout in place, giving each of them the
+// default weight and no flags so that a pinned map surviving an agent
+// upgrade keeps working without a restart. Entries already on the current
+// layout are left untouched.
+func MigrateService4Map() error {
+	return Service4Map.DumpWithCallback(func(key, value []byte) error {
+		if len(value) != legacyService4ValueSize {
+			return nil
+		}
+
+		svcKey := Service4Key{}
+		if err := binary.Read(bytes.NewBuffer(key), binary.LittleEndian, &svcKey); err != nil {
+			return fmt.Errorf("unable to convert key during migration: %s", err)
+		}
+
+		svcVal, err := parseService4Value(value)
+		if err != nil {
+			return err
+		}
+		// parseService4Value returns Port and RevNAT in host byte order;
+		// the map stores them in network byte order.
+		svcVal.Port = common.Swab16(svcVal.Port)
+		svcVal.RevNAT = common.Swab16(svcVal.RevNAT)
+
+		if err := Service4Map.Update(&svcKey, svcVal); err != nil {
+			return fmt.Errorf("unable to migrate %+v: %s", svcKey, err)
+		}
+
+		return nil
+	})
 }
 
 type RevNat4Key uint16
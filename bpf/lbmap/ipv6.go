@@ -0,0 +1,238 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package lbmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"github.com/cilium/cilium/common"
+	"github.com/cilium/cilium/common/bpf"
+	"github.com/cilium/cilium/common/types"
+)
+
+var (
+	Service6Map = bpf.NewMap(common.BPFCiliumMaps+"/cilium_lb6_services",
+		bpf.MapTypeHash,
+		int(unsafe.Sizeof(Service6Key{})),
+		int(unsafe.Sizeof(Service6Value{})),
+		lbMapMaxEntries(serviceMapWeight, int(unsafe.Sizeof(Service6Key{})), int(unsafe.Sizeof(Service6Value{}))))
+	RevNat6Map = bpf.NewMap(common.BPFCiliumMaps+"/cilium_lb6_reverse_nat",
+		bpf.MapTypeHash,
+		int(unsafe.Sizeof(RevNat6Key(0))),
+		int(unsafe.Sizeof(RevNat6Value{})),
+		lbMapMaxEntries(revNatMapWeight, int(unsafe.Sizeof(RevNat6Key(0))), int(unsafe.Sizeof(RevNat6Value{}))))
+)
+
+// Must match 'struct lb6_key' in "bpf/lib/common.h"
+type Service6Key struct {
+	Address types.IPv6
+	Port    uint16
+	Slave   uint16
+}
+
+func (k Service6Key) IsIPv6() bool           { return true }
+func (k Service6Key) Map() *bpf.Map          { return Service6Map }
+func (k Service6Key) NewValue() bpf.MapValue { return &Service6Value{} }
+
+func (k Service6Key) GetKeyPtr() unsafe.Pointer {
+	return unsafe.Pointer(&k)
+}
+
+func (k Service6Key) MapDelete() error {
+	return k.Map().Delete(k)
+}
+
+func NewService6Key(ip net.IP, port uint16, slave uint16) *Service6Key {
+	key := Service6Key{
+		Port:  common.Swab16(port),
+		Slave: slave,
+	}
+
+	copy(key.Address[:], ip.To16())
+
+	return &key
+}
+
+// Must match 'struct lb6_service' in "bpf/lib/common.h"
+type Service6Value struct {
+	Address types.IPv6
+	Port    uint16
+	Count   uint16
+	RevNAT  uint16
+	Weight  uint16
+	Flags   uint16
+}
+
+// legacyService6Value is the on-disk layout of Service6Value before Weight
+// and Flags were added. See legacyService4Value for why this exists.
+type legacyService6Value struct {
+	Address types.IPv6
+	Port    uint16
+	Count   uint16
+	RevNAT  uint16
+}
+
+var legacyService6ValueSize = int(unsafe.Sizeof(legacyService6Value{}))
+
+func NewService6Value(count uint16, target net.IP, port uint16, revNat uint16, weight uint16, flags ServiceFlags) *Service6Value {
+	svc := Service6Value{
+		Count:  count,
+		RevNAT: common.Swab16(revNat),
+		Port:   common.Swab16(port),
+		Weight: weight,
+		Flags:  uint16(flags),
+	}
+
+	copy(svc.Address[:], target.To16())
+
+	return &svc
+}
+
+func (s Service6Value) GetValuePtr() unsafe.Pointer {
+	return unsafe.Pointer(&s)
+}
+
+func Service6DumpParser(key []byte, value []byte) (bpf.MapKey, bpf.MapValue, error) {
+	keyBuf := bytes.NewBuffer(key)
+	svcKey := Service6Key{}
+
+	if err := binary.Read(keyBuf, binary.LittleEndian, &svcKey); err != nil {
+		return nil, nil, fmt.Errorf("Unable to convert key: %s\n", err)
+	}
+
+	svcKey.Port = common.Swab16(svcKey.Port)
+
+	svcVal, err := parseService6Value(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &svcKey, svcVal, nil
+}
+
+// parseService6Value mirrors parseService4Value: it transparently upgrades
+// entries still written in the pre-Weight/Flags layout.
+func parseService6Value(value []byte) (*Service6Value, error) {
+	valueBuf := bytes.NewBuffer(value)
+	svcVal := Service6Value{}
+
+	if len(value) == legacyService6ValueSize {
+		legacy := legacyService6Value{}
+		if err := binary.Read(valueBuf, binary.LittleEndian, &legacy); err != nil {
+			return nil, fmt.Errorf("Unable to convert legacy value: %s\n", err)
+		}
+
+		svcVal.Address = legacy.Address
+		svcVal.Port = legacy.Port
+		svcVal.Count = legacy.Count
+		svcVal.RevNAT = legacy.RevNAT
+		svcVal.Weight = 1 // legacy entries were selected with equal probability
+	} else if err := binary.Read(valueBuf, binary.LittleEndian, &svcVal); err != nil {
+		return nil, fmt.Errorf("Unable to convert value: %s\n", err)
+	}
+
+	svcVal.Port = common.Swab16(svcVal.Port)
+	svcVal.RevNAT = common.Swab16(svcVal.RevNAT)
+
+	return &svcVal, nil
+}
+
+// MigrateService6Map is the IPv6 counterpart of MigrateService4Map. Entries
+// already on the current layout are left untouched.
+func MigrateService6Map() error {
+	return Service6Map.DumpWithCallback(func(key, value []byte) error {
+		if len(value) != legacyService6ValueSize {
+			return nil
+		}
+
+		svcKey := Service6Key{}
+		if err := binary.Read(bytes.NewBuffer(key), binary.LittleEndian, &svcKey); err != nil {
+			return fmt.Errorf("unable to convert key during migration: %s", err)
+		}
+
+		svcVal, err := parseService6Value(value)
+		if err != nil {
+			return err
+		}
+		// parseService6Value returns Port and RevNAT in host byte order;
+		// the map stores them in network byte order.
+		svcVal.Port = common.Swab16(svcVal.Port)
+		svcVal.RevNAT = common.Swab16(svcVal.RevNAT)
+
+		if err := Service6Map.Update(&svcKey, svcVal); err != nil {
+			return fmt.Errorf("unable to migrate %+v: %s", svcKey, err)
+		}
+
+		return nil
+	})
+}
+
+type RevNat6Key uint16
+
+func NewRevNat6Key(value uint16) RevNat6Key {
+	return RevNat6Key(common.Swab16(value))
+}
+
+func (k RevNat6Key) IsIPv6() bool           { return true }
+func (k RevNat6Key) Map() *bpf.Map          { return RevNat6Map }
+func (k RevNat6Key) NewValue() bpf.MapValue { return &RevNat6Value{} }
+func (k RevNat6Key) GetKeyPtr() unsafe.Pointer {
+	return unsafe.Pointer(&k)
+}
+
+type RevNat6Value struct {
+	Address types.IPv6
+	Port    uint16
+}
+
+func (k RevNat6Value) GetValuePtr() unsafe.Pointer {
+	return unsafe.Pointer(&k)
+}
+
+func NewRevNat6Value(ip net.IP, port uint16) *RevNat6Value {
+	revNat := RevNat6Value{
+		Port: common.Swab16(port),
+	}
+
+	copy(revNat.Address[:], ip.To16())
+
+	return &revNat
+}
+
+func RevNat6DumpParser(key []byte, value []byte) (bpf.MapKey, bpf.MapValue, error) {
+	var revNat RevNat6Value
+	var ukey uint16
+
+	keyBuf := bytes.NewBuffer(key)
+	valueBuf := bytes.NewBuffer(value)
+
+	if err := binary.Read(keyBuf, binary.LittleEndian, &ukey); err != nil {
+		return nil, nil, fmt.Errorf("Unable to convert key: %s\n", err)
+	}
+	revKey := NewRevNat6Key(ukey)
+
+	if err := binary.Read(valueBuf, binary.LittleEndian, &revNat); err != nil {
+		return nil, nil, fmt.Errorf("Unable to convert value: %s\n", err)
+	}
+
+	revNat.Port = common.Swab16(revNat.Port)
+
+	return &revKey, &revNat, nil
+}
@@ -0,0 +1,60 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Package lbmap represents the BPF maps used by the load balancer.
+package lbmap
+
+import (
+	"net"
+
+	"github.com/cilium/cilium/common/bpf"
+)
+
+// maxEntries is the fixed number of entries each of the BPF LB maps is
+// created with when lbMapMaxEntries cannot derive a size (see
+// defaultMaxEntries). It is not an upper bound: a cgroup-derived size or
+// the LBMAP_MAX_ENTRIES override can exceed it.
+const maxEntries = 65536
+
+// NewServiceKey creates the appropriate IPv4 or IPv6 service key depending
+// on the family of ip, so that callers do not need to know which family a
+// given service belongs to in order to program it.
+func NewServiceKey(ip net.IP, port uint16, slave uint16) bpf.MapKey {
+	if ip.To4() == nil {
+		return NewService6Key(ip, port, slave)
+	}
+
+	return NewService4Key(ip, port, slave)
+}
+
+// NewServiceValue creates the appropriate IPv4 or IPv6 service value
+// depending on the family of target.
+func NewServiceValue(count uint16, target net.IP, port uint16, revNat uint16, weight uint16, flags ServiceFlags) bpf.MapValue {
+	if target.To4() == nil {
+		return NewService6Value(count, target, port, revNat, weight, flags)
+	}
+
+	return NewService4Value(count, target, port, revNat, weight, flags)
+}
+
+// NewRevNatValue creates the appropriate IPv4 or IPv6 reverse NAT value
+// depending on the family of ip.
+func NewRevNatValue(ip net.IP, port uint16) bpf.MapValue {
+	if ip.To4() == nil {
+		return NewRevNat6Value(ip, port)
+	}
+
+	return NewRevNat4Value(ip, port)
+}
@@ -0,0 +1,107 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package lbmap
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// defaultMaxEntries is the number of entries each LB map is given when its
+// size cannot be derived from the cgroup memory limit, e.g. because the
+// agent is not running under a cgroup, the limit is unset ("max"), or the
+// host is not Linux. This is the value cilium shipped with before
+// cgroup-aware sizing was introduced.
+const defaultMaxEntries = maxEntries
+
+// bucketOverhead is a rough estimate of the per-entry bookkeeping a BPF hash
+// map keeps in addition to the key and value themselves (bucket pointers,
+// hash, etc). It only needs to be close enough to keep us from
+// over-committing the cgroup's memory budget.
+const bucketOverhead = 16
+
+// headroom is subtracted from the cgroup memory limit before it is divided
+// up between the LB maps, leaving room for the rest of the agent.
+const headroom = 64 * 1024 * 1024
+
+// minMaxEntries is the floor a map's capacity is clamped to once a cgroup
+// memory limit has been observed but leaves no room above headroom (or not
+// enough room to afford even minMaxEntries entries for this map's share).
+// These are precisely the tight-cgroup cases this feature exists for, so
+// falling back to defaultMaxEntries there would re-introduce the
+// over-provisioning the feature is meant to avoid; a small map that fits
+// the budget is the correct outcome instead.
+const minMaxEntries = 256
+
+// mapWeight is the relative share of the memory budget a map is entitled
+// to. Service maps store more per entry than RevNAT maps but are looked up
+// far more often, so they are weighted evenly with them.
+const (
+	serviceMapWeight    = 1
+	revNatMapWeight     = 1
+	sockRevNatMapWeight = 1
+	affinityMapWeight   = 1
+)
+
+// totalMapWeight is the sum of the weights of every LB map sized through
+// lbMapMaxEntries: Service4/Service6, RevNat4/RevNat6, SockRevNat4 and
+// AffinityMatch4. It must be updated whenever a map is added to or removed
+// from that set, otherwise the cgroup memory budget is split among fewer
+// maps than actually draw from it.
+const totalMapWeight = 2*serviceMapWeight + 2*revNatMapWeight + sockRevNatMapWeight + affinityMapWeight
+
+// lbMapMaxEntries returns the number of entries a LB map with the given
+// weight, key size and value size should be created with. The LBMAP_MAX_ENTRIES
+// environment variable, when set to a positive integer, overrides any
+// derived value, mirroring how GOMEMLIMIT/AUTOMEMLIMIT can be disabled.
+func lbMapMaxEntries(weight, keySize, valueSize int) int {
+	if raw := os.Getenv("LBMAP_MAX_ENTRIES"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+	}
+
+	if runtime.GOOS != "linux" {
+		return defaultMaxEntries
+	}
+
+	limit, ok := cgroupMemoryLimit()
+	if !ok {
+		return defaultMaxEntries
+	}
+
+	return maxEntriesForLimit(limit, weight, keySize, valueSize)
+}
+
+// maxEntriesForLimit derives a map's capacity from an already-known cgroup
+// memory limit. It is split out from lbMapMaxEntries so the arithmetic can
+// be unit tested without real cgroup files.
+func maxEntriesForLimit(limit int64, weight, keySize, valueSize int) int {
+	budget := limit - headroom
+	if budget <= 0 {
+		return minMaxEntries
+	}
+
+	share := budget * int64(weight) / int64(totalMapWeight)
+
+	n := int(share / int64(keySize+valueSize+bucketOverhead))
+	if n < minMaxEntries {
+		return minMaxEntries
+	}
+
+	return n
+}
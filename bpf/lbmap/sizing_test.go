@@ -0,0 +1,99 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package lbmap
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMaxEntriesForLimit(t *testing.T) {
+	const keySize = 8
+	const valueSize = 8
+	perEntry := int64(keySize + valueSize + bucketOverhead)
+
+	tests := []struct {
+		name   string
+		limit  int64
+		weight int
+		want   int
+	}{
+		{
+			name:   "budget exactly used up by headroom floors, rather than reverting to the stock default",
+			limit:  headroom,
+			weight: serviceMapWeight,
+			want:   minMaxEntries,
+		},
+		{
+			name:   "budget below headroom also floors",
+			limit:  headroom / 2,
+			weight: serviceMapWeight,
+			want:   minMaxEntries,
+		},
+		{
+			name:   "share too small to afford a single entry floors",
+			limit:  headroom + 1,
+			weight: serviceMapWeight,
+			want:   minMaxEntries,
+		},
+		{
+			name:   "ample budget is split proportionally to weight",
+			limit:  headroom + perEntry*int64(totalMapWeight)*1000,
+			weight: serviceMapWeight,
+			want:   1000,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := maxEntriesForLimit(tt.limit, tt.weight, keySize, valueSize)
+			if got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLBMapMaxEntriesEnvOverride(t *testing.T) {
+	if err := os.Setenv("LBMAP_MAX_ENTRIES", "42"); err != nil {
+		t.Fatalf("unable to set LBMAP_MAX_ENTRIES: %s", err)
+	}
+	defer os.Unsetenv("LBMAP_MAX_ENTRIES")
+
+	if got := lbMapMaxEntries(serviceMapWeight, 8, 8); got != 42 {
+		t.Fatalf("got %d, want 42", got)
+	}
+}
+
+func TestLBMapMaxEntriesEnvOverrideIgnoresNonPositive(t *testing.T) {
+	for _, raw := range []string{"not-a-number", "0", "-5"} {
+		raw := raw
+		t.Run(raw, func(t *testing.T) {
+			if err := os.Setenv("LBMAP_MAX_ENTRIES", raw); err != nil {
+				t.Fatalf("unable to set LBMAP_MAX_ENTRIES: %s", err)
+			}
+			defer os.Unsetenv("LBMAP_MAX_ENTRIES")
+
+			// A garbage or non-positive override must not be honored; it
+			// falls through to the normal cgroup/default sizing path
+			// rather than producing a zero or negative map size.
+			if got := lbMapMaxEntries(serviceMapWeight, 8, 8); got <= 0 {
+				t.Fatalf("got %d, want a positive entry count", got)
+			}
+		})
+	}
+}
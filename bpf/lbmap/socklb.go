@@ -0,0 +1,174 @@
+//
+// Copyright 2016 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package lbmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"unsafe"
+
+	"github.com/cilium/cilium/common"
+	"github.com/cilium/cilium/common/bpf"
+	"github.com/cilium/cilium/common/types"
+)
+
+// ErrSocketLBProgNotImplemented is returned by EnableSocketLB once
+// SockRevNat4Map has been pinned successfully, to signal that attaching the
+// BPF_PROG_TYPE_CGROUP_SOCK_ADDR program itself is not implemented yet.
+// Callers can compare against it with errors.Is to tell this apart from a
+// genuine cgroup-detection or pin failure.
+var ErrSocketLBProgNotImplemented = errors.New("socket-level LB program attach is not yet implemented")
+
+const (
+	socketLBProgPath = "/sys/fs/bpf/cilium/sock_lb"
+	socketLBMapPin   = "/sys/fs/bpf/cilium/cilium_lb4_sock_reverse_nat"
+)
+
+// SockRevNat4Map is consumed by a BPF_PROG_TYPE_CGROUP_SOCK_ADDR program
+// attached to the unified cgroup v2 hierarchy, translating connect(2) calls
+// to a ClusterIP into a direct connection to one of its backends so that
+// east-west traffic needs no per-packet NAT/reverse-NAT lookup.
+var SockRevNat4Map = bpf.NewMap(common.BPFCiliumMaps+"/cilium_lb4_sock_reverse_nat",
+	bpf.MapTypeHash,
+	int(unsafe.Sizeof(SockRevNat4Key{})),
+	int(unsafe.Sizeof(SockRevNat4Value{})),
+	lbMapMaxEntries(sockRevNatMapWeight, int(unsafe.Sizeof(SockRevNat4Key{})), int(unsafe.Sizeof(SockRevNat4Value{}))))
+
+// Must match 'struct lb4_sock_key' in "bpf/lib/common.h"
+type SockRevNat4Key struct {
+	Cookie  uint64
+	Address types.IPv4
+	Port    uint16
+	Pad     uint16
+}
+
+func (k SockRevNat4Key) IsIPv6() bool           { return false }
+func (k SockRevNat4Key) Map() *bpf.Map          { return SockRevNat4Map }
+func (k SockRevNat4Key) NewValue() bpf.MapValue { return &SockRevNat4Value{} }
+
+func (k SockRevNat4Key) GetKeyPtr() unsafe.Pointer {
+	return unsafe.Pointer(&k)
+}
+
+func (k SockRevNat4Key) MapDelete() error {
+	return k.Map().Delete(k)
+}
+
+func NewSockRevNat4Key(cookie uint64, address net.IP, port uint16) *SockRevNat4Key {
+	key := SockRevNat4Key{
+		Cookie: cookie,
+		Port:   common.Swab16(port),
+	}
+
+	copy(key.Address[:], address.To4())
+
+	return &key
+}
+
+// Must match 'struct lb4_sock_value' in "bpf/lib/common.h"
+type SockRevNat4Value struct {
+	Address types.IPv4
+	Port    uint16
+	RevNAT  uint16
+}
+
+func NewSockRevNat4Value(address net.IP, port uint16, revNat uint16) *SockRevNat4Value {
+	val := SockRevNat4Value{
+		Port:   common.Swab16(port),
+		RevNAT: common.Swab16(revNat),
+	}
+
+	copy(val.Address[:], address.To4())
+
+	return &val
+}
+
+func (v SockRevNat4Value) GetValuePtr() unsafe.Pointer {
+	return unsafe.Pointer(&v)
+}
+
+// SockRevNat4DumpParser shares its byte-order handling with
+// Service4DumpParser so that "cilium bpf lb list" can show both tables.
+func SockRevNat4DumpParser(key []byte, value []byte) (bpf.MapKey, bpf.MapValue, error) {
+	keyBuf := bytes.NewBuffer(key)
+	valueBuf := bytes.NewBuffer(value)
+	sockKey := SockRevNat4Key{}
+	sockVal := SockRevNat4Value{}
+
+	if err := binary.Read(keyBuf, binary.LittleEndian, &sockKey); err != nil {
+		return nil, nil, fmt.Errorf("Unable to convert key: %s\n", err)
+	}
+
+	sockKey.Port = common.Swab16(sockKey.Port)
+
+	if err := binary.Read(valueBuf, binary.LittleEndian, &sockVal); err != nil {
+		return nil, nil, fmt.Errorf("Unable to convert value: %s\n", err)
+	}
+
+	sockVal.Port = common.Swab16(sockVal.Port)
+	sockVal.RevNAT = common.Swab16(sockVal.RevNAT)
+
+	return &sockKey, &sockVal, nil
+}
+
+// haveCgroupV2 reports whether the unified cgroup v2 hierarchy is mounted,
+// matching the way docker/moby detects CgroupVersion.
+func haveCgroupV2() bool {
+	raw, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(raw), "\n") {
+		if strings.Contains(line, " - cgroup2 ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EnableSocketLB pins SockRevNat4Map at socketLBMapPin under
+// /sys/fs/bpf/cilium so a BPF_PROG_TYPE_CGROUP_SOCK_ADDR program can find
+// it. It refuses to activate on pure cgroup v1 systems.
+//
+// It does not load, pin or attach the socket load-balancing program at
+// socketLBProgPath: once the map has been pinned it returns an error
+// wrapping ErrSocketLBProgNotImplemented, rather than reporting success,
+// and cgroupRoot only appears in that error's message. Callers that
+// only care about the map side (the parser and dump tooling above) can
+// treat that specific error as non-fatal with errors.Is; any other error
+// means the map itself could not be made ready.
+func EnableSocketLB(cgroupRoot string) error {
+	if !haveCgroupV2() {
+		return fmt.Errorf("socket-level load balancing requires the cgroup v2 unified hierarchy")
+	}
+
+	if SockRevNat4Map == nil {
+		return fmt.Errorf("unable to pin %s: map was not created", socketLBMapPin)
+	}
+
+	if err := SockRevNat4Map.Pin(socketLBMapPin); err != nil {
+		return fmt.Errorf("unable to pin %s: %s", socketLBMapPin, err)
+	}
+
+	return fmt.Errorf("attaching %s to cgroup %s: %w", socketLBProgPath, cgroupRoot, ErrSocketLBProgNotImplemented)
+}